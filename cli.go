@@ -1,19 +1,90 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
 	"fmt"
 	"github.com/fatih/color"
+	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
+// hostList is a flag.Value that collects repeated occurrences of a flag
+// (e.g. -tls-host) into a slice.
+type hostList []string
+
+func (h *hostList) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *hostList) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// loadSigningKey reads and parses the PEM-encoded RSA private key at
+// path. An empty path is not an error; it just means the caller should
+// let NewServerHandler generate a key for us.
+func loadSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM-encoded key", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key in %s: %s", path, err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA private key", path)
+	}
+
+	return key, nil
+}
+
 func main() {
 	var callbackUrl string
+	var signingKeyPath string
+	var usersFilePath string
+	var clientsFilePath string
+	var tlsCertPath string
+	var tlsKeyPath string
+	var tlsHosts hostList
 
 	cyan := color.New(color.FgCyan).SprintFunc()
 
 	portPtr := flag.Int("port", 8080, "Port to listen on")
 	flag.StringVar(&callbackUrl, "callback-url", "http://localhost:8000/auth/callback", "OAuth2 Callback URL")
+	flag.StringVar(&signingKeyPath, "signing-key", "", "Path to a PEM-encoded RSA private key to sign tokens with")
+	flag.StringVar(&usersFilePath, "users-file", "", "Path to an htpasswd file of valid logins (default: accept any email)")
+	flag.StringVar(&clientsFilePath, "clients-file", "", "Path to a JSON file of valid OAuth2 clients (default: accept any client)")
+	flag.StringVar(&tlsCertPath, "tls-cert", "", "Path to a PEM-encoded TLS certificate to serve with")
+	flag.StringVar(&tlsKeyPath, "tls-key", "", "Path to a PEM-encoded TLS private key to serve with")
+	flagTlsAuto := flag.Bool("tls-auto", false, "Serve TLS with an in-memory self-signed certificate (ignored if -tls-cert/-tls-key are set)")
+	flag.Var(&tlsHosts, "tls-host", "Additional host to include as a SAN on the -tls-auto certificate (may be repeated)")
 
 	flagNoColor := flag.Bool("no-color", false, "Disable color output")
 
@@ -23,20 +94,93 @@ func main() {
 		color.NoColor = true
 	}
 
-	handler := NewServerHandler(&ServerConfig{
-		CallbackUrl: Urlify(callbackUrl),
+	signingKey, err := loadSigningKey(signingKeyPath)
+
+	if err != nil {
+		fmt.Fprintf(color.Error, "Error loading -signing-key: %s\n", err)
+		return
+	}
+
+	var userBackend UserBackend
+
+	if usersFilePath != "" {
+		userBackend, err = NewHtpasswdUserBackend(usersFilePath)
+
+		if err != nil {
+			fmt.Fprintf(color.Error, "Error loading -users-file: %s\n", err)
+			return
+		}
+	}
+
+	var clientRegistry ClientRegistry
+
+	if clientsFilePath != "" {
+		clientRegistry, err = NewJSONClientRegistry(clientsFilePath)
+
+		if err != nil {
+			fmt.Fprintf(color.Error, "Error loading -clients-file: %s\n", err)
+			return
+		}
+	}
+
+	var tlsConfig *tls.Config
+
+	scheme := "http"
+
+	if tlsCertPath != "" || tlsKeyPath != "" {
+		scheme = "https"
+	} else if *flagTlsAuto {
+		scheme = "https"
+
+		cert, certPEM, err := generateSelfSignedCert(append([]string{"localhost"}, tlsHosts...))
+
+		if err != nil {
+			fmt.Fprintf(color.Error, "Error generating -tls-auto certificate: %s\n", err)
+			return
+		}
+
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		fmt.Fprintf(color.Error, "%s", certPEM)
+	}
+
+	baseURL := fmt.Sprintf("%s://localhost:%d", scheme, *portPtr)
+
+	handler, err := NewServerHandler(&ServerConfig{
+		CallbackUrl:    Urlify(callbackUrl),
+		SigningKey:     signingKey,
+		BaseURL:        baseURL,
+		UserBackend:    userBackend,
+		ClientRegistry: clientRegistry,
 	})
 
-	authorizeUrl := fmt.Sprintf("http://localhost:%d%s", *portPtr, Urls.Reverse("authorize"))
-	tokenUrl := fmt.Sprintf("http://localhost:%d%s", *portPtr, Urls.Reverse("token"))
+	if err != nil {
+		fmt.Fprintf(color.Error, "Error creating server: %s\n", err)
+		return
+	}
+
+	authorizeUrl := fmt.Sprintf("%s%s", baseURL, Urls.Reverse("authorize"))
+	tokenUrl := fmt.Sprintf("%s%s", baseURL, Urls.Reverse("token"))
+	discoveryUrl := fmt.Sprintf("%s%s", baseURL, Urls.Reverse("discovery"))
 
 	fmt.Fprintf(color.Output, "My OAuth2 authorize URL is %s.\n", cyan(authorizeUrl))
 	fmt.Fprintf(color.Output, "My OAuth2 token URL is %s.\n", cyan(tokenUrl))
+	fmt.Fprintf(color.Output, "My OIDC discovery document is at %s.\n", cyan(discoveryUrl))
 	fmt.Fprintf(color.Output, "Your client's callback URL is %s.\n", cyan(callbackUrl))
 	fmt.Fprintf(color.Output, "To change settings, call me with the -help flag.\n\n")
 
 	fmt.Fprintf(color.Output, "Starting fake-cloud.gov server on port %s.\n", cyan(*portPtr))
 
 	http.HandleFunc("/", handler)
-	http.ListenAndServe(fmt.Sprintf(":%d", *portPtr), nil)
+
+	addr := fmt.Sprintf(":%d", *portPtr)
+
+	if tlsConfig != nil {
+		server := &http.Server{Addr: addr, TLSConfig: tlsConfig}
+		server.ListenAndServeTLS("", "")
+	} else if tlsCertPath != "" || tlsKeyPath != "" {
+		http.ListenAndServeTLS(addr, tlsCertPath, tlsKeyPath, nil)
+	} else {
+		http.ListenAndServe(addr, nil)
+	}
 }
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultAccessTokenLifetime is used when ServerConfig.AccessTokenLifetime
+// is zero.
+const defaultAccessTokenLifetime = 600
+
+// defaultIssuer is used when ServerConfig.Issuer is empty.
+const defaultIssuer = "http://localhost"
+
+// refreshTokenPrefix is prepended to an email address to form the
+// (deliberately non-opaque) refresh tokens this server issues.
+const refreshTokenPrefix = "fake_oauth2_refresh_token:"
+
+// ServerConfig configures the behavior of the handler returned by
+// NewServerHandler.
+type ServerConfig struct {
+	// CallbackUrl is the URL that the fake UAA will redirect to once the
+	// user "logs in" on the authorize page.
+	CallbackUrl *url.URL
+
+	// AccessTokenLifetime is the number of seconds an issued access
+	// token is valid for. Defaults to defaultAccessTokenLifetime.
+	AccessTokenLifetime int64
+
+	// SigningKey is the RSA key pair used to sign access and ID tokens.
+	// If nil, NewServerHandler generates one at startup.
+	SigningKey *rsa.PrivateKey
+
+	// BaseURL is the scheme and host this server is reachable at (e.g.
+	// "https://localhost:8443"). It's used to default Issuer when Issuer
+	// is empty, so that callers serving over TLS don't have to set both.
+	BaseURL string
+
+	// Issuer is the value used for the "iss" claim on issued tokens and
+	// advertised in the OIDC discovery document. Defaults to BaseURL, or
+	// defaultIssuer if BaseURL is also empty.
+	Issuer string
+
+	// UserBackend authenticates logins on the authorize page. Defaults
+	// to one that accepts any non-empty email.
+	UserBackend UserBackend
+
+	// ClientRegistry authenticates client_id/client_secret pairs
+	// presented to the token endpoint. Defaults to one that accepts
+	// any client.
+	ClientRegistry ClientRegistry
+
+	// keyID is the "kid" advertised in the JWKS document and stamped on
+	// tokens signed with SigningKey. It is derived from SigningKey once
+	// NewServerHandler has resolved it.
+	keyID string
+
+	// revokedMu guards revoked.
+	revokedMu sync.Mutex
+
+	// revoked holds the identifiers of tokens that /revoke has been
+	// asked to revoke: an access token's "jti", or a refresh token's
+	// full "fake_oauth2_refresh_token:<email>" string.
+	revoked map[string]bool
+}
+
+// revoke marks id (an access token's jti, or a full refresh token) as
+// revoked.
+func (config *ServerConfig) revoke(id string) {
+	config.revokedMu.Lock()
+	defer config.revokedMu.Unlock()
+	config.revoked[id] = true
+}
+
+// isRevoked reports whether id was previously passed to revoke.
+func (config *ServerConfig) isRevoked(id string) bool {
+	config.revokedMu.Lock()
+	defer config.revokedMu.Unlock()
+	return config.revoked[id]
+}
+
+// AccessTokenClaims are the JWT claims carried by access tokens issued by
+// the fake UAA.
+type AccessTokenClaims struct {
+	Aud      []string `json:"aud"`
+	Scope    []string `json:"scope"`
+	Username string   `json:"user_name"`
+	Email    string   `json:"email"`
+	jwt.StandardClaims
+}
+
+// tokenResponse is the JSON body returned by the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IdToken      string `json:"id_token,omitempty"`
+	Jti          string `json:"jti"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	TokenType    string `json:"token_type"`
+}
+
+// idTokenClaims are the JWT claims carried by OIDC ID tokens issued
+// when an authorize request included "openid" in its scope.
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Nonce         string `json:"nonce,omitempty"`
+	jwt.StandardClaims
+}
+
+// urlTable maps logical names of endpoints to their paths, so that code
+// like cli.go can refer to "authorize" or "token" without hard-coding
+// the path.
+type urlTable map[string]string
+
+// Reverse returns the path registered under name, panicking if name is
+// unknown. It's only ever called with names we've registered ourselves,
+// so a panic indicates a programming error.
+func (t urlTable) Reverse(name string) string {
+	path, ok := t[name]
+
+	if !ok {
+		panic(fmt.Sprintf("no URL named %q", name))
+	}
+
+	return path
+}
+
+// Urls is the canonical list of endpoints exposed by the fake UAA.
+var Urls = urlTable{
+	"authorize":  "/oauth/authorize",
+	"token":      "/oauth/token",
+	"discovery":  "/.well-known/openid-configuration",
+	"jwks":       "/token_keys",
+	"userinfo":   "/userinfo",
+	"introspect": "/introspect",
+	"revoke":     "/revoke",
+}
+
+// Urlify parses rawUrl, panicking if it's invalid. It exists to make
+// constructing *url.URL values for tests and flags less verbose.
+func Urlify(rawUrl string) *url.URL {
+	parsed, err := url.Parse(rawUrl)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// NewServerHandler builds the http.HandlerFunc that serves the entire
+// fake UAA: the login page, the token endpoint, OIDC discovery, JWKS,
+// userinfo, introspection, revocation, and the static assets the login
+// page references.
+func NewServerHandler(config *ServerConfig) (http.HandlerFunc, error) {
+	if config == nil {
+		return nil, errors.New("config must be non-nil")
+	}
+
+	if config.CallbackUrl == nil {
+		return nil, errors.New("config.CallbackUrl must be non-nil")
+	}
+
+	if config.SigningKey == nil {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+		if err != nil {
+			return nil, fmt.Errorf("generating signing key: %s", err)
+		}
+
+		config.SigningKey = key
+	}
+
+	if config.Issuer == "" {
+		if config.BaseURL != "" {
+			config.Issuer = config.BaseURL
+		} else {
+			config.Issuer = defaultIssuer
+		}
+	}
+
+	if config.UserBackend == nil {
+		config.UserBackend = permissiveUserBackend{}
+	}
+
+	if config.ClientRegistry == nil {
+		config.ClientRegistry = permissiveClientRegistry{}
+	}
+
+	config.keyID = keyID(&config.SigningKey.PublicKey)
+	config.revoked = map[string]bool{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case Urls["authorize"]:
+			handleAuthorize(config, w, r)
+		case Urls["token"]:
+			handleToken(config, w, r)
+		case Urls["discovery"]:
+			handleDiscovery(config, w, r)
+		case Urls["jwks"]:
+			handleJWKS(config, w, r)
+		case Urls["introspect"]:
+			handleIntrospect(config, w, r)
+		case Urls["revoke"]:
+			handleRevoke(config, w, r)
+		case Urls["userinfo"]:
+			handleUserinfo(config, w, r)
+		case "/fake-cloud.gov.svg":
+			serveLogo(w, r)
+		case "/style.css":
+			serveStylesheet(w, r)
+		default:
+			writeTextError(w, http.StatusNotFound, "Not found")
+		}
+	}, nil
+}
+
+// writeTextError writes a plain-text error response, matching the
+// minimal Content-Type (no charset) that fake UAA clients expect.
+func writeTextError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	fmt.Fprint(w, message)
+}
+
+// keyID derives a stable "kid" for pub, so the same key always produces
+// the same kid across the JWKS document and issued tokens.
+func keyID(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+
+	if err != nil {
+		panic(err)
+	}
+
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+type loginPageData struct {
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	Nonce               string
+	Error               string
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>fake-cloud.gov</title>
+<link rel="stylesheet" href="/style.css">
+</head>
+<body>
+<img src="/fake-cloud.gov.svg" alt="cloud.gov" class="logo">
+<p>This is a fake cloud.gov login page. Enter an email address to log in.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="POST" action="/oauth/authorize">
+<input type="email" name="email" placeholder="email@example.com" required>
+<input type="password" name="password" placeholder="password">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="submit" value="Login">
+</form>
+</body>
+</html>
+`))
+
+// pkceCodeSeparator joins the fields we pack into an authorization code
+// whenever any of them is present. It's stateless by design: the code
+// itself carries everything the token endpoint needs.
+const pkceCodeSeparator = "|"
+
+// authorizationRequest is everything from a GET /oauth/authorize that
+// the token endpoint later needs, packed into the authorization "code"
+// since this server keeps no server-side state.
+type authorizationRequest struct {
+	Email               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	Nonce               string
+}
+
+// encodeCode builds the authorization "code" for req. When req has no
+// code challenge, scope or nonce, the code is just the email, preserving
+// this server's original behavior.
+func encodeCode(req authorizationRequest) string {
+	if req.CodeChallenge == "" && req.Scope == "" && req.Nonce == "" {
+		return req.Email
+	}
+
+	method := req.CodeChallengeMethod
+
+	if method == "" {
+		method = "plain"
+	}
+
+	raw := strings.Join([]string{req.Email, req.CodeChallenge, method, req.Scope, req.Nonce}, pkceCodeSeparator)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCode reverses encodeCode. If code doesn't carry a packed
+// authorizationRequest (including all codes issued before PKCE/OIDC
+// support existed), it's treated as a bare email, as before.
+func decodeCode(code string) authorizationRequest {
+	decoded, err := base64.RawURLEncoding.DecodeString(code)
+
+	if err != nil {
+		return authorizationRequest{Email: code}
+	}
+
+	parts := strings.SplitN(string(decoded), pkceCodeSeparator, 5)
+
+	if len(parts) != 5 {
+		return authorizationRequest{Email: code}
+	}
+
+	return authorizationRequest{
+		Email:               parts[0],
+		CodeChallenge:       parts[1],
+		CodeChallengeMethod: parts[2],
+		Scope:               parts[3],
+		Nonce:               parts[4],
+	}
+}
+
+// verifyPKCE reports whether verifier satisfies challenge under the
+// given code_challenge_method ("S256" or "plain").
+func verifyPKCE(challenge string, method string, verifier string) bool {
+	computed := verifier
+
+	if method == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// handleAuthorize implements GET /oauth/authorize. With no "email"
+// parameter it renders a login page; once the login page's (POST)
+// form has been submitted with an "email", it redirects to the
+// client's callback URL with an authorization "code" (derived from
+// the email, since this server is stateless).
+//
+// The login form submits via POST, rather than the initial GET that
+// carries it here, so that the password it collects doesn't end up in
+// the URL query string (and thus access logs/browser history).
+func handleAuthorize(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		params = r.PostForm
+	}
+
+	email := params.Get("email")
+
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html")
+
+		loginPageTemplate.Execute(w, loginPageData{
+			State:               params.Get("state"),
+			CodeChallenge:       params.Get("code_challenge"),
+			CodeChallengeMethod: params.Get("code_challenge_method"),
+			Scope:               params.Get("scope"),
+			Nonce:               params.Get("nonce"),
+		})
+
+		return
+	}
+
+	if !config.UserBackend.Authenticate(email, params.Get("password")) {
+		w.Header().Set("Content-Type", "text/html")
+
+		loginPageTemplate.Execute(w, loginPageData{
+			State:               params.Get("state"),
+			CodeChallenge:       params.Get("code_challenge"),
+			CodeChallengeMethod: params.Get("code_challenge_method"),
+			Scope:               params.Get("scope"),
+			Nonce:               params.Get("nonce"),
+			Error:               "Invalid email or password.",
+		})
+
+		return
+	}
+
+	redirectUrl := *config.CallbackUrl
+	redirectQuery := redirectUrl.Query()
+
+	redirectQuery.Set("code", encodeCode(authorizationRequest{
+		Email:               email,
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+		Scope:               params.Get("scope"),
+		Nonce:               params.Get("nonce"),
+	}))
+
+	if state := params.Get("state"); state != "" {
+		redirectQuery.Set("state", state)
+	}
+
+	redirectUrl.RawQuery = redirectQuery.Encode()
+
+	http.Redirect(w, r, redirectUrl.String(), http.StatusFound)
+}
+
+// newJti generates a random identifier suitable for the "jti" claim.
+func newJti() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return fmt.Sprintf("%x", buf)
+}
+
+// handleToken implements POST /oauth/token, supporting the
+// "authorization_code" and "refresh_token" grant types.
+func handleToken(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	form := r.PostForm
+
+	clientId := form.Get("client_id")
+
+	if clientId == "" {
+		writeTextError(w, http.StatusBadRequest, "'client_id' is missing or empty")
+		return
+	}
+
+	if !config.ClientRegistry.Authenticate(clientId, form.Get("client_secret")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	var email, scope, nonce string
+
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code := form.Get("code")
+
+		if code == "" {
+			writeTextError(w, http.StatusBadRequest, "'code' is missing or empty")
+			return
+		}
+
+		req := decodeCode(code)
+		email = req.Email
+		scope = req.Scope
+		nonce = req.Nonce
+
+		if req.CodeChallenge != "" {
+			verifier := form.Get("code_verifier")
+
+			if verifier == "" || !verifyPKCE(req.CodeChallenge, req.CodeChallengeMethod, verifier) {
+				writeTextError(w, http.StatusBadRequest, "'code_verifier' is missing or invalid")
+				return
+			}
+		}
+	case "refresh_token":
+		refreshToken := form.Get("refresh_token")
+
+		if !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+			writeTextError(w, http.StatusBadRequest, "'refresh_token' is missing or malformed")
+			return
+		}
+
+		if config.isRevoked(refreshToken) {
+			writeTextError(w, http.StatusBadRequest, "'refresh_token' has been revoked")
+			return
+		}
+
+		email = strings.TrimPrefix(refreshToken, refreshTokenPrefix)
+	default:
+		writeTextError(w, http.StatusBadRequest, "'grant_type' must be 'authorization_code' or 'refresh_token'")
+		return
+	}
+
+	accessToken, claims, err := issueAccessToken(config, clientId, email)
+
+	if err != nil {
+		writeTextError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := tokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    config.AccessTokenLifetime,
+		Jti:          claims.Id,
+		RefreshToken: "fake_oauth2_refresh_token:" + email,
+		Scope:        strings.Join(claims.Scope, " "),
+		TokenType:    "bearer",
+	}
+
+	if response.ExpiresIn == 0 {
+		response.ExpiresIn = defaultAccessTokenLifetime
+	}
+
+	if scopeIncludesOpenID(scope) {
+		idToken, err := issueIDToken(config, clientId, email, nonce)
+
+		if err != nil {
+			writeTextError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.IdToken = idToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// scopeIncludesOpenID reports whether scope (a space-separated scope
+// list) includes "openid".
+func scopeIncludesOpenID(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == "openid" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issueAccessToken builds and RS256-signs an access token for email on
+// behalf of clientId, using config.SigningKey.
+func issueAccessToken(config *ServerConfig, clientId string, email string) (string, *AccessTokenClaims, error) {
+	lifetime := config.AccessTokenLifetime
+
+	if lifetime == 0 {
+		lifetime = defaultAccessTokenLifetime
+	}
+
+	now := time.Now()
+
+	claims := &AccessTokenClaims{
+		Aud:      []string{clientId},
+		Scope:    []string{"uaa.user"},
+		Username: email,
+		Email:    email,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    config.Issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(lifetime) * time.Second).Unix(),
+			Id:        newJti(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = config.keyID
+
+	signed, err := token.SignedString(config.SigningKey)
+
+	return signed, claims, err
+}
+
+// issueIDToken builds and RS256-signs an OIDC ID token for email on
+// behalf of clientId, using config.SigningKey.
+func issueIDToken(config *ServerConfig, clientId string, email string, nonce string) (string, error) {
+	lifetime := config.AccessTokenLifetime
+
+	if lifetime == 0 {
+		lifetime = defaultAccessTokenLifetime
+	}
+
+	now := time.Now()
+
+	claims := idTokenClaims{
+		Email:         email,
+		EmailVerified: true,
+		Nonce:         nonce,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   email,
+			Audience:  clientId,
+			Issuer:    config.Issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Duration(lifetime) * time.Second).Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = config.keyID
+
+	return token.SignedString(config.SigningKey)
+}
+
+// handleDiscovery implements GET /.well-known/openid-configuration.
+func handleDiscovery(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	doc := map[string]interface{}{
+		"issuer":                 config.Issuer,
+		"authorization_endpoint": config.Issuer + Urls.Reverse("authorize"),
+		"token_endpoint":         config.Issuer + Urls.Reverse("token"),
+		"userinfo_endpoint":      config.Issuer + Urls.Reverse("userinfo"),
+		"jwks_uri":               config.Issuer + Urls.Reverse("jwks"),
+		"introspection_endpoint": config.Issuer + Urls.Reverse("introspect"),
+		"revocation_endpoint":    config.Issuer + Urls.Reverse("revoke"),
+
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_post"},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// handleJWKS implements GET /token_keys, publishing the public half of
+// config.SigningKey.
+func handleJWKS(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	pub := config.SigningKey.PublicKey
+
+	jwk := map[string]interface{}{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": config.keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []interface{}{jwk},
+	})
+}
+
+// bearerPrefix precedes the access token in an Authorization header.
+const bearerPrefix = "Bearer "
+
+// writeInvalidTokenError writes the 401 response OIDC clients expect
+// when a bearer token is missing, malformed, or revoked.
+func writeInvalidTokenError(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// handleUserinfo implements GET /userinfo, per the OIDC UserInfo
+// endpoint spec.
+func handleUserinfo(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	authHeader := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(authHeader, bearerPrefix) {
+		writeInvalidTokenError(w)
+		return
+	}
+
+	claims, err := parseAccessToken(config, strings.TrimPrefix(authHeader, bearerPrefix))
+
+	if err != nil || config.isRevoked(claims.Id) {
+		writeInvalidTokenError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sub":            claims.Email,
+		"user_name":      claims.Username,
+		"email":          claims.Email,
+		"email_verified": true,
+	})
+}
+
+// parseAccessToken verifies tokenString as one of our own RS256 access
+// tokens and returns its claims.
+func parseAccessToken(config *ServerConfig, tokenString string) (*AccessTokenClaims, error) {
+	claims := &AccessTokenClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return &config.SigningKey.PublicKey, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("token is invalid")
+	}
+
+	return claims, nil
+}
+
+// firstOrEmpty returns the first element of values, or "" if it's empty.
+func firstOrEmpty(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// introspectionResponse is the JSON body returned by /introspect, per
+// RFC 7662.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	Username  string `json:"username,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+}
+
+// handleIntrospect implements POST /introspect (RFC 7662) for both the
+// access and refresh tokens this server issues.
+func handleIntrospect(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	token := r.PostForm.Get("token")
+
+	response := introspectionResponse{}
+
+	if strings.HasPrefix(token, refreshTokenPrefix) {
+		if !config.isRevoked(token) {
+			response = introspectionResponse{
+				Active:    true,
+				Username:  strings.TrimPrefix(token, refreshTokenPrefix),
+				TokenType: "refresh_token",
+			}
+		}
+	} else if claims, err := parseAccessToken(config, token); err == nil && !config.isRevoked(claims.Id) {
+		response = introspectionResponse{
+			Active:    true,
+			Scope:     strings.Join(claims.Scope, " "),
+			Username:  claims.Username,
+			ClientID:  firstOrEmpty(claims.Aud),
+			TokenType: "access_token",
+			Exp:       claims.ExpiresAt,
+			Iat:       claims.IssuedAt,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleRevoke implements POST /revoke (RFC 7009). Per the RFC, it
+// responds 200 whether or not the token was recognized.
+func handleRevoke(config *ServerConfig, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	token := r.PostForm.Get("token")
+
+	if strings.HasPrefix(token, refreshTokenPrefix) {
+		config.revoke(token)
+	} else if claims, err := parseAccessToken(config, token); err == nil {
+		config.revoke(claims.Id)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+const logoSvg = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 60">
+<text x="10" y="35">fake-cloud.gov</text>
+</svg>
+`
+
+const stylesheetCss = `body {
+	font-family: sans-serif;
+	text-align: center;
+	margin-top: 10%;
+}
+
+.logo {
+	width: 200px;
+}
+`
+
+func serveLogo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	fmt.Fprint(w, logoSvg)
+}
+
+func serveStylesheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css")
+	fmt.Fprint(w, stylesheetCss)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -19,14 +23,6 @@ type ParsedTokenResponse struct {
 	TokenType string
 }
 
-type AccessTokenClaims struct {
-	Aud []string `json:"aud"`
-	Scope []string `json:"scope"`
-	Username string `json:"user_name"`
-	Email string `json:"email"`
-	jwt.StandardClaims
-}
-
 func assertInt64(t *testing.T, a int64, b int64) {
 	if (a != b) {
 		t.Errorf("Expected '%d' == '%d'", a, b);
@@ -91,6 +87,22 @@ func handle(request *http.Request) *httptest.ResponseRecorder {
 	return recorder
 }
 
+// newTestHandler builds the same default http.HandlerFunc as handle,
+// for tests that need the handler itself (e.g. to drive it through
+// more than one request).
+func newTestHandler(t *testing.T) http.HandlerFunc {
+	handler, err := NewServerHandler(&ServerConfig{
+		CallbackUrl:         Urlify("http://client/callback"),
+		AccessTokenLifetime: 600,
+	})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return handler
+}
+
 func TestNewServerHandlerReturnsErrWhenConfigIsNil(t *testing.T) {
 	_, err := NewServerHandler(nil)
 
@@ -118,12 +130,16 @@ func TestLoginPageWorksWithoutQueryArgs(t *testing.T) {
 func TestLoginPageWorksWithQueryArgs(t *testing.T) {
 	recorder := handle(&http.Request{
 		Method: "GET",
-		URL:    Urlify("/oauth/authorize?state=blah"),
+		URL:    Urlify("/oauth/authorize?state=blah&code_challenge=abc123&code_challenge_method=S256&scope=openid+email&nonce=xyz789"),
 	})
 
 	assertStatus(t, recorder, 200)
 	assertHeader(t, recorder, "Content-Type", "text/html")
 	assertBodyMatches(t, recorder, `type="hidden" name="state" value="blah"`)
+	assertBodyMatches(t, recorder, `type="hidden" name="code_challenge" value="abc123"`)
+	assertBodyMatches(t, recorder, `type="hidden" name="code_challenge_method" value="S256"`)
+	assertBodyMatches(t, recorder, `type="hidden" name="scope" value="openid email"`)
+	assertBodyMatches(t, recorder, `type="hidden" name="nonce" value="xyz789"`)
 }
 
 func TestRedirectToCallbackWorks(t *testing.T) {
@@ -182,8 +198,69 @@ func TestRefreshAccessTokenErrorsWhenRefreshTokenIsMalformed(t *testing.T) {
 	}, "'refresh_token' is missing or malformed")	
 }
 
+// fetchJWKSPublicKey fetches the JWKS document from handler and decodes
+// its (sole) RSA public key, so tests can verify tokens against the
+// published key rather than config.SigningKey. The key's kid is not checked.
+func fetchJWKSPublicKey(t *testing.T, handler http.HandlerFunc) *rsa.PublicKey {
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "GET",
+		URL:    Urlify(Urls.Reverse("jwks")),
+	})
+
+	var doc struct {
+		Keys []struct {
+			N string `json:"n"`
+			E string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Error unmarshaling JWKS: %s", err.Error())
+	}
+
+	if len(doc.Keys) != 1 {
+		t.Fatalf("Expected exactly one JWKS key, got %d", len(doc.Keys))
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(doc.Keys[0].N)
+
+	if err != nil {
+		t.Fatalf("Error decoding JWKS modulus: %s", err.Error())
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(doc.Keys[0].E)
+
+	if err != nil {
+		t.Fatalf("Error decoding JWKS exponent: %s", err.Error())
+	}
+
+	exponent := 0
+
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: exponent,
+	}
+}
+
 func GetTokenResponse(t *testing.T, postForm url.Values, response *ParsedTokenResponse) {
-	recorder := handle(&http.Request{
+	handler, err := NewServerHandler(&ServerConfig{
+		CallbackUrl: Urlify("http://client/callback"),
+		AccessTokenLifetime: 600,
+	})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
 		Method: "POST",
 		URL:    Urlify("/oauth/token"),
 		PostForm: postForm,
@@ -194,7 +271,7 @@ func GetTokenResponse(t *testing.T, postForm url.Values, response *ParsedTokenRe
 
 	var rawResponse tokenResponse
 
-	err := json.Unmarshal(recorder.Body.Bytes(), &rawResponse)
+	err = json.Unmarshal(recorder.Body.Bytes(), &rawResponse)
 
 	if err != nil {
 		t.Errorf("Error unmarshaling response: %s", err.Error())
@@ -206,8 +283,10 @@ func GetTokenResponse(t *testing.T, postForm url.Values, response *ParsedTokenRe
 	response.Scope = rawResponse.Scope
 	response.TokenType = rawResponse.TokenType
 
+	publicKey := fetchJWKSPublicKey(t, handler)
+
 	token, err := jwt.ParseWithClaims(rawResponse.AccessToken, &AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte("unused secret key (for verification)"), nil
+		return publicKey, nil
 	})
 
 	if err != nil {
@@ -265,6 +344,503 @@ func TestExchangeCodeForAccessTokenWorks(t *testing.T) {
 	assertString(t, response.AccessToken.Email, "foo@bar.gov")
 }
 
+// codeFromAuthorize drives the GET /oauth/authorize redirect step of
+// the flow, returning the authorization "code" it issues.
+func codeFromAuthorize(t *testing.T, handler http.HandlerFunc, query string) string {
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "GET",
+		URL:    Urlify("/oauth/authorize?" + query),
+	})
+
+	assertStatus(t, recorder, 302)
+
+	location, err := url.Parse(recorder.HeaderMap.Get("Location"))
+
+	if err != nil {
+		t.Fatalf("Error parsing Location header: %s", err.Error())
+	}
+
+	return location.Query().Get("code")
+}
+
+func TestExchangeCodeForAccessTokenWorksWithS256PKCE(t *testing.T) {
+	handler := newTestHandler(t)
+
+	verifier := "s3cr3t-verifier"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	code := codeFromAuthorize(t, handler, "email=foo@bar.gov&code_challenge="+challenge+"&code_challenge_method=S256")
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{code},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+			"code_verifier": []string{verifier},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+}
+
+func TestExchangeCodeForAccessTokenWorksWithPlainPKCE(t *testing.T) {
+	handler := newTestHandler(t)
+
+	verifier := "s3cr3t-verifier"
+	code := codeFromAuthorize(t, handler, "email=foo@bar.gov&code_challenge="+verifier+"&code_challenge_method=plain")
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{code},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+			"code_verifier": []string{verifier},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+}
+
+func TestExchangeCodeForAccessTokenErrorsWhenVerifierIsMissing(t *testing.T) {
+	handler := newTestHandler(t)
+
+	code := codeFromAuthorize(t, handler, "email=foo@bar.gov&code_challenge=abc123&code_challenge_method=plain")
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{code},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+		},
+	})
+
+	assertStatus(t, recorder, 400)
+	assertBody(t, recorder, "'code_verifier' is missing or invalid")
+}
+
+func TestExchangeCodeForAccessTokenErrorsWhenVerifierIsMismatched(t *testing.T) {
+	handler := newTestHandler(t)
+
+	code := codeFromAuthorize(t, handler, "email=foo@bar.gov&code_challenge=abc123&code_challenge_method=plain")
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{code},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+			"code_verifier": []string{"wrong-verifier"},
+		},
+	})
+
+	assertStatus(t, recorder, 400)
+	assertBody(t, recorder, "'code_verifier' is missing or invalid")
+}
+
+func assertIntrospectActive(t *testing.T, handler http.HandlerFunc, token string, active bool) {
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/introspect"),
+		PostForm: url.Values{
+			"token": []string{token},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+
+	var response struct {
+		Active bool `json:"active"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	if response.Active != active {
+		t.Errorf("Expected introspection active=%v, got %v", active, response.Active)
+	}
+}
+
+func revoke(t *testing.T, handler http.HandlerFunc, token string) {
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/revoke"),
+		PostForm: url.Values{
+			"token": []string{token},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+}
+
+func TestIntrospectAccessTokenWorks(t *testing.T) {
+	handler := newTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{"foo@bar.gov"},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+		},
+	})
+
+	var rawResponse tokenResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rawResponse); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	assertIntrospectActive(t, handler, rawResponse.AccessToken, true)
+
+	revoke(t, handler, rawResponse.AccessToken)
+
+	assertIntrospectActive(t, handler, rawResponse.AccessToken, false)
+}
+
+func TestIntrospectRefreshTokenWorks(t *testing.T) {
+	handler := newTestHandler(t)
+
+	refreshToken := "fake_oauth2_refresh_token:foo@bar.com"
+
+	assertIntrospectActive(t, handler, refreshToken, true)
+}
+
+func TestIntrospectInactiveForUnknownToken(t *testing.T) {
+	handler := newTestHandler(t)
+
+	assertIntrospectActive(t, handler, "not.a.real.token", false)
+}
+
+func TestRevokeThenRefreshFails(t *testing.T) {
+	handler := newTestHandler(t)
+
+	refreshToken := "fake_oauth2_refresh_token:foo@bar.com"
+
+	revoke(t, handler, refreshToken)
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"refresh_token"},
+			"refresh_token": []string{refreshToken},
+		},
+	})
+
+	assertStatus(t, recorder, 400)
+	assertHeader(t, recorder, "Content-Type", "text/plain")
+	assertBody(t, recorder, "'refresh_token' has been revoked")
+}
+
+type fakeUserBackend struct {
+	validEmail    string
+	validPassword string
+}
+
+func (b fakeUserBackend) Authenticate(email string, password string) bool {
+	return email == b.validEmail && password == b.validPassword
+}
+
+type fakeClientRegistry struct {
+	validClientId     string
+	validClientSecret string
+}
+
+func (r fakeClientRegistry) Authenticate(clientId string, clientSecret string) bool {
+	return clientId == r.validClientId && clientSecret == r.validClientSecret
+}
+
+func TestAuthorizeWithUserBackend(t *testing.T) {
+	tests := []struct {
+		name         string
+		userBackend  UserBackend
+		query        string
+		expectStatus int
+	}{
+		{"default backend accepts any email", nil, "email=foo@bar.gov", 302},
+		{"strict backend accepts a valid login", fakeUserBackend{validEmail: "foo@bar.gov", validPassword: "hunter2"}, "email=foo@bar.gov&password=hunter2", 302},
+		{"strict backend rejects an invalid login", fakeUserBackend{validEmail: "foo@bar.gov", validPassword: "hunter2"}, "email=foo@bar.gov&password=wrong", 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewServerHandler(&ServerConfig{
+				CallbackUrl: Urlify("http://client/callback"),
+				UserBackend: test.userBackend,
+			})
+
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			recorder := httptest.NewRecorder()
+
+			handler(recorder, &http.Request{
+				Method: "GET",
+				URL:    Urlify("/oauth/authorize?" + test.query),
+			})
+
+			assertStatus(t, recorder, test.expectStatus)
+		})
+	}
+}
+
+func TestTokenWithClientRegistry(t *testing.T) {
+	tests := []struct {
+		name           string
+		clientRegistry ClientRegistry
+		postForm       url.Values
+		expectStatus   int
+	}{
+		{
+			"default registry accepts any client",
+			nil,
+			url.Values{"client_id": []string{"anything"}, "client_secret": []string{"anything"}, "grant_type": []string{"wut"}},
+			400,
+		},
+		{
+			"strict registry accepts a valid client",
+			fakeClientRegistry{validClientId: "baz", validClientSecret: "baz"},
+			url.Values{"client_id": []string{"baz"}, "client_secret": []string{"baz"}, "grant_type": []string{"wut"}},
+			400,
+		},
+		{
+			"strict registry rejects an invalid client",
+			fakeClientRegistry{validClientId: "baz", validClientSecret: "baz"},
+			url.Values{"client_id": []string{"baz"}, "client_secret": []string{"wrong"}, "grant_type": []string{"wut"}},
+			401,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewServerHandler(&ServerConfig{
+				CallbackUrl:    Urlify("http://client/callback"),
+				ClientRegistry: test.clientRegistry,
+			})
+
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			recorder := httptest.NewRecorder()
+
+			handler(recorder, &http.Request{
+				Method:   "POST",
+				URL:      Urlify("/oauth/token"),
+				PostForm: test.postForm,
+			})
+
+			assertStatus(t, recorder, test.expectStatus)
+		})
+	}
+}
+
+func TestExchangeCodeForAccessTokenIncludesIdTokenWithOpenIDScope(t *testing.T) {
+	handler := newTestHandler(t)
+
+	code := codeFromAuthorize(t, handler, "email=foo@bar.gov&scope=openid+email&nonce=abc123")
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{code},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+
+	var rawResponse tokenResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rawResponse); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	if rawResponse.IdToken == "" {
+		t.Fatalf("Expected an id_token, got none")
+	}
+
+	publicKey := fetchJWKSPublicKey(t, handler)
+
+	token, err := jwt.ParseWithClaims(rawResponse.IdToken, &idTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error parsing id_token JWT: %s", err.Error())
+	}
+
+	claims := token.Claims.(*idTokenClaims)
+
+	assertString(t, claims.Email, "foo@bar.gov")
+	assertString(t, claims.Subject, "foo@bar.gov")
+	assertString(t, claims.Audience, "baz")
+	assertString(t, claims.Nonce, "abc123")
+
+	if !claims.EmailVerified {
+		t.Errorf("Expected email_verified to be true")
+	}
+}
+
+func TestExchangeCodeForAccessTokenOmitsIdTokenWithoutOpenIDScope(t *testing.T) {
+	handler := newTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{"foo@bar.gov"},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+		},
+	})
+
+	var rawResponse tokenResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rawResponse); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	assertString(t, rawResponse.IdToken, "")
+}
+
+func TestUserinfoErrorsWithoutValidBearerToken(t *testing.T) {
+	recorder := handle(&http.Request{
+		Method: "GET",
+		URL:    Urlify("/userinfo"),
+		Header: http.Header{
+			"Authorization": []string{"Bearer not-the-right-token"},
+		},
+	})
+
+	assertStatus(t, recorder, 401)
+	assertHeader(t, recorder, "WWW-Authenticate", `Bearer error="invalid_token"`)
+}
+
+func TestUserinfoRoundTripWorks(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tokenRecorder := httptest.NewRecorder()
+
+	handler(tokenRecorder, &http.Request{
+		Method: "POST",
+		URL:    Urlify("/oauth/token"),
+		PostForm: url.Values{
+			"code":          []string{"foo@bar.gov"},
+			"client_id":     []string{"baz"},
+			"client_secret": []string{"baz"},
+			"grant_type":    []string{"authorization_code"},
+		},
+	})
+
+	var rawResponse tokenResponse
+
+	if err := json.Unmarshal(tokenRecorder.Body.Bytes(), &rawResponse); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	recorder := httptest.NewRecorder()
+
+	handler(recorder, &http.Request{
+		Method: "GET",
+		URL:    Urlify("/userinfo"),
+		Header: http.Header{
+			"Authorization": []string{"Bearer " + rawResponse.AccessToken},
+		},
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+
+	var userinfo struct {
+		Sub           string `json:"sub"`
+		UserName      string `json:"user_name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &userinfo); err != nil {
+		t.Fatalf("Error unmarshaling response: %s", err.Error())
+	}
+
+	assertString(t, userinfo.Email, "foo@bar.gov")
+	assertString(t, userinfo.UserName, "foo@bar.gov")
+
+	if !userinfo.EmailVerified {
+		t.Errorf("Expected email_verified to be true")
+	}
+}
+
+func TestDiscoveryDocumentWorks(t *testing.T) {
+	recorder := handle(&http.Request{
+		Method: "GET",
+		URL:    Urlify("/.well-known/openid-configuration"),
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+	assertBodyMatches(t, recorder, `"jwks_uri":"http://localhost/token_keys"`)
+	assertBodyMatches(t, recorder, `"authorization_endpoint":"http://localhost/oauth/authorize"`)
+}
+
+func TestJWKSWorks(t *testing.T) {
+	recorder := handle(&http.Request{
+		Method: "GET",
+		URL:    Urlify("/token_keys"),
+	})
+
+	assertStatus(t, recorder, 200)
+	assertHeader(t, recorder, "Content-Type", "application/json")
+	assertBodyMatches(t, recorder, `"kty":"RSA"`)
+}
+
 func TestGetSvgLogoWorks(t *testing.T) {
 	recorder := handle(&http.Request{
 		Method: "GET",